@@ -0,0 +1,35 @@
+package dist
+
+import (
+	"compress/gzip"
+	"io"
+)
+
+// Compressor wraps an underlying io.Writer, returning an io.WriteCloser that compresses
+// whatever tar bytes are written to it. Close must be called to flush any trailing
+// compressed data; it does not close the underlying writer.
+type Compressor interface {
+	// Compress returns a WriteCloser whose writes are compressed and forwarded to w.
+	Compress(w io.Writer) (io.WriteCloser, error)
+
+	// MediaType names the encoding this Compressor produces (e.g. "gzip", "zstd",
+	// "estargz"), for use in layer blob file extensions and media types.
+	MediaType() string
+}
+
+// gzipCompressor is the default Compressor, used when none is supplied via
+// WithCompressor.
+type gzipCompressor struct{}
+
+// NewGzipCompressor returns a Compressor that produces standard gzip-compressed layers.
+func NewGzipCompressor() Compressor {
+	return gzipCompressor{}
+}
+
+func (gzipCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriterLevel(w, gzip.DefaultCompression)
+}
+
+func (gzipCompressor) MediaType() string {
+	return "gzip"
+}
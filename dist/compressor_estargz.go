@@ -0,0 +1,61 @@
+package dist
+
+import (
+	"io"
+
+	"github.com/containerd/stargz-snapshotter/estargz"
+	"github.com/pkg/errors"
+)
+
+// estargzCompressor produces seekable gzip (eStargz) layers. Unlike plain gzip or zstd,
+// registries that understand the format can serve partial/lazy blob pulls from it the
+// way containers/image does with FetchPartialBlobs, which matters for large buildpack
+// layers that a daemon would otherwise have to pull in full before use.
+type estargzCompressor struct{}
+
+// NewEstargzCompressor returns a Compressor that produces eStargz layers.
+func NewEstargzCompressor() Compressor {
+	return estargzCompressor{}
+}
+
+func (estargzCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	pr, pw := io.Pipe()
+	ew := estargz.NewWriter(w)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- ew.AppendTar(pr)
+	}()
+
+	return &estargzWriteCloser{pw: pw, ew: ew, done: done}, nil
+}
+
+func (estargzCompressor) MediaType() string {
+	return "estargz"
+}
+
+// estargzWriteCloser adapts estargz.Writer's whole-tar-stream AppendTar method to the
+// incremental io.WriteCloser shape Compress requires, by piping writes to a goroutine
+// that feeds AppendTar.
+type estargzWriteCloser struct {
+	pw   *io.PipeWriter
+	ew   *estargz.Writer
+	done chan error
+}
+
+func (e *estargzWriteCloser) Write(p []byte) (int, error) {
+	return e.pw.Write(p)
+}
+
+func (e *estargzWriteCloser) Close() error {
+	if err := e.pw.Close(); err != nil {
+		return errors.Wrap(err, "closing estargz pipe")
+	}
+	if err := <-e.done; err != nil {
+		return errors.Wrap(err, "appending tar to estargz writer")
+	}
+	if _, err := e.ew.Close(); err != nil {
+		return errors.Wrap(err, "closing estargz writer")
+	}
+	return nil
+}
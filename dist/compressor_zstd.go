@@ -0,0 +1,29 @@
+package dist
+
+import (
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+// zstdCompressor produces zstd-compressed layers, trading the ubiquity of gzip for
+// faster compression and smaller blobs.
+type zstdCompressor struct{}
+
+// NewZstdCompressor returns a Compressor that produces zstd-compressed layers.
+func NewZstdCompressor() Compressor {
+	return zstdCompressor{}
+}
+
+func (zstdCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	zw, err := zstd.NewWriter(w)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating zstd writer")
+	}
+	return zw, nil
+}
+
+func (zstdCompressor) MediaType() string {
+	return "zstd"
+}
@@ -0,0 +1,257 @@
+package dist
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/blakesmith/ar"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+	"github.com/ulikunitz/xz"
+)
+
+// xzMagic and zstdMagic are the leading bytes of an xz stream (the .xz file format, 1.0.4
+// section 2.1.1.1) and a zstd frame (RFC 8878 section 3.1.1), respectively. control.tar
+// and data.tar members have been xz-compressed by default since dpkg 1.17.6, with zstd
+// increasingly common on newer distros - only legacy .debs still use gzip.
+var (
+	xzMagic   = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// debianBuildpack adapts a Debian binary package (a `.deb` file) into a Buildpack: its
+// control file supplies the BuildpackDescriptor, and data.tar.* - rooted under prefix -
+// supplies the contents embedBuildpackTar relocates under /cnb/buildpacks/{id}/{ver}/.
+// This lets ops teams package existing Debian-built tooling as a buildpack without
+// hand-authoring a buildpack.toml.
+type debianBuildpack struct {
+	descriptor BuildpackDescriptor
+	debPath    string
+	prefix     string
+}
+
+// NewDebianBuildpack returns a Buildpack backed by the Debian package at debPath. prefix
+// is prepended to every path in data.tar.* before it is embedded (typically "/", to
+// preserve the package's own layout).
+func NewDebianBuildpack(debPath string, prefix string) (Buildpack, error) {
+	control, err := readDebMember(debPath, "control.tar.", "control")
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading control file from '%s'", debPath)
+	}
+
+	descriptor, err := parseDebianControlFile(control)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing control file from '%s'", debPath)
+	}
+
+	return &debianBuildpack{
+		descriptor: descriptor,
+		debPath:    debPath,
+		prefix:     prefix,
+	}, nil
+}
+
+func (b *debianBuildpack) Descriptor() BuildpackDescriptor {
+	return b.descriptor
+}
+
+// Open streams data.tar.* as a plain tar, with every entry's name rewritten under
+// b.prefix.
+func (b *debianBuildpack) Open() (io.ReadCloser, error) {
+	fh, err := os.Open(b.debPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open deb '%s'", b.debPath)
+	}
+
+	dataTar, err := findArMember(fh, "data.tar.")
+	if err != nil {
+		fh.Close()
+		return nil, errors.Wrapf(err, "read deb '%s'", b.debPath)
+	}
+
+	dr, err := decompressMember(dataTar)
+	if err != nil {
+		fh.Close()
+		return nil, errors.Wrap(err, "opening data.tar")
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		defer fh.Close()
+		defer dr.Close()
+
+		pw.CloseWithError(rerootTar(dr, pw, b.prefix))
+	}()
+
+	return pr, nil
+}
+
+// rerootTar copies every entry from the tar stream r into a new tar written to w, with
+// name rewritten to be rooted under prefix.
+func rerootTar(r io.Reader, w io.Writer, prefix string) error {
+	tr := tar.NewReader(r)
+	tw := tar.NewWriter(w)
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "reading data.tar entry")
+		}
+
+		header.Name = path.Join(prefix, path.Clean(header.Name))
+		if header.Typeflag == tar.TypeLink {
+			// Linkname for a hardlink refers to another member's original (pre-reroot)
+			// Name, so it needs the same treatment or the link ends up dangling.
+			header.Linkname = path.Join(prefix, path.Clean(header.Linkname))
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return errors.Wrapf(err, "writing header for '%s'", header.Name)
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return errors.Wrapf(err, "writing contents of '%s'", header.Name)
+		}
+	}
+
+	return tw.Close()
+}
+
+// readDebMember returns the contents of file within the compressed tar member of the `ar`
+// archive at debPath whose name starts with arMemberPrefix (e.g. "control.tar."), whatever
+// its compression format.
+func readDebMember(debPath, arMemberPrefix, file string) ([]byte, error) {
+	fh, err := os.Open(debPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open deb '%s'", debPath)
+	}
+	defer fh.Close()
+
+	member, err := findArMember(fh, arMemberPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	dr, err := decompressMember(member)
+	if err != nil {
+		return nil, errors.Wrapf(err, "opening %s member", arMemberPrefix)
+	}
+	defer dr.Close()
+
+	tr := tar.NewReader(dr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil, errors.Errorf("%s member is missing a %s file", arMemberPrefix, file)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if path.Base(path.Clean(header.Name)) != file {
+			continue
+		}
+
+		buf := &bytes.Buffer{}
+		if _, err := io.Copy(buf, tr); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+}
+
+// findArMember scans the ar archive read from r and returns a reader positioned at the
+// start of the first member whose name starts with namePrefix.
+func findArMember(r io.Reader, namePrefix string) (io.Reader, error) {
+	arr := ar.NewReader(r)
+	for {
+		header, err := arr.Next()
+		if err == io.EOF {
+			return nil, errors.Errorf("missing %s* member", namePrefix)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if strings.HasPrefix(strings.TrimRight(header.Name, "/"), namePrefix) {
+			return arr, nil
+		}
+	}
+}
+
+// decompressMember sniffs r for the gzip, xz, or zstd magic number - the three
+// compression formats dpkg-deb has used for control.tar/data.tar members over the years -
+// and returns a reader over the decompressed tar stream.
+func decompressMember(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+
+	magic, err := br.Peek(len(xzMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(magic, xzMagic):
+		xr, err := xz.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(xr), nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	default:
+		return nil, errors.Errorf("unrecognized compression (magic bytes % x)", magic)
+	}
+}
+
+// parseDebianControlFile parses a Debian control file's field:value lines into a
+// BuildpackDescriptor, mapping Package -> ID, Version -> Version, and
+// Description -> Info.Name.
+func parseDebianControlFile(data []byte) (BuildpackDescriptor, error) {
+	var descriptor BuildpackDescriptor
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			break
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		switch strings.TrimSpace(parts[0]) {
+		case "Package":
+			descriptor.Info.ID = strings.TrimSpace(parts[1])
+		case "Version":
+			descriptor.Info.Version = strings.TrimSpace(parts[1])
+		case "Description":
+			descriptor.Info.Name = strings.TrimSpace(parts[1])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return BuildpackDescriptor{}, err
+	}
+
+	if descriptor.Info.ID == "" {
+		return BuildpackDescriptor{}, errors.New("control file is missing a Package field")
+	}
+
+	return descriptor, nil
+}
@@ -0,0 +1,183 @@
+package dist
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/blakesmith/ar"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// writeTestDeb assembles a minimal .deb at debPath: an ar archive holding a
+// control.tar.<ext> and data.tar.<ext> member, compressed with compress.
+func writeTestDeb(t *testing.T, debPath string, compress func(*testing.T, []byte) []byte, ext string, controlFiles, dataFiles map[string]string) {
+	t.Helper()
+
+	controlTar := compress(t, newTestTar(t, controlFiles))
+	dataTar := compress(t, newTestTar(t, dataFiles))
+
+	fh, err := os.Create(debPath)
+	if err != nil {
+		t.Fatalf("creating deb: %s", err)
+	}
+	defer fh.Close()
+
+	aw := ar.NewWriter(fh)
+	if err := aw.WriteGlobalHeader(); err != nil {
+		t.Fatalf("writing ar global header: %s", err)
+	}
+	if err := aw.WriteHeader(&ar.Header{Name: "control.tar." + ext, Size: int64(len(controlTar))}); err != nil {
+		t.Fatalf("writing control header: %s", err)
+	}
+	if _, err := aw.Write(controlTar); err != nil {
+		t.Fatalf("writing control contents: %s", err)
+	}
+	if err := aw.WriteHeader(&ar.Header{Name: "data.tar." + ext, Size: int64(len(dataTar))}); err != nil {
+		t.Fatalf("writing data header: %s", err)
+	}
+	if _, err := aw.Write(dataTar); err != nil {
+		t.Fatalf("writing data contents: %s", err)
+	}
+}
+
+func xzBytes(t *testing.T, b []byte) []byte {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	xzw, err := xz.NewWriter(buf)
+	if err != nil {
+		t.Fatalf("creating xz writer: %s", err)
+	}
+	if _, err := xzw.Write(b); err != nil {
+		t.Fatalf("xz-compressing: %s", err)
+	}
+	if err := xzw.Close(); err != nil {
+		t.Fatalf("closing xz writer: %s", err)
+	}
+	return buf.Bytes()
+}
+
+func zstdBytes(t *testing.T, b []byte) []byte {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	zw, err := zstd.NewWriter(buf)
+	if err != nil {
+		t.Fatalf("creating zstd writer: %s", err)
+	}
+	if _, err := zw.Write(b); err != nil {
+		t.Fatalf("zstd-compressing: %s", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("closing zstd writer: %s", err)
+	}
+	return buf.Bytes()
+}
+
+func TestNewDebianBuildpack(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		ext      string
+		compress func(*testing.T, []byte) []byte
+	}{
+		{name: "gzip", ext: "gz", compress: gzipBytes},
+		{name: "xz", ext: "xz", compress: xzBytes},
+		{name: "zstd", ext: "zst", compress: zstdBytes},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "debian-buildpack")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			debPath := filepath.Join(dir, "tool.deb")
+			writeTestDeb(t, debPath, tt.compress, tt.ext,
+				map[string]string{"./control": "Package: acme/tool\nVersion: 9.9.9\nDescription: a tool\n"},
+				map[string]string{"usr/bin/tool": "binary-contents"},
+			)
+
+			bp, err := NewDebianBuildpack(debPath, "/")
+			if err != nil {
+				t.Fatalf("NewDebianBuildpack() returned error: %s", err)
+			}
+
+			desc := bp.Descriptor()
+			if desc.Info.ID != "acme/tool" || desc.Info.Version != "9.9.9" || desc.Info.Name != "a tool" {
+				t.Fatalf("unexpected descriptor: %+v", desc)
+			}
+
+			rc, err := bp.Open()
+			if err != nil {
+				t.Fatalf("Open() returned error: %s", err)
+			}
+			defer rc.Close()
+
+			tr := tar.NewReader(rc)
+			var found bool
+			for {
+				header, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("reading embedded tar: %s", err)
+				}
+				if header.Name == "/usr/bin/tool" {
+					found = true
+					contents, err := ioutil.ReadAll(tr)
+					if err != nil {
+						t.Fatalf("reading entry contents: %s", err)
+					}
+					if string(contents) != "binary-contents" {
+						t.Fatalf("unexpected entry contents: %s", contents)
+					}
+				}
+			}
+			if !found {
+				t.Fatalf("expected /usr/bin/tool entry")
+			}
+		})
+	}
+}
+
+func TestNewDebianBuildpackUnrecognizedCompression(t *testing.T) {
+	dir, err := ioutil.TempDir("", "debian-buildpack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	debPath := filepath.Join(dir, "tool.deb")
+	fh, err := os.Create(debPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aw := ar.NewWriter(fh)
+	if err := aw.WriteGlobalHeader(); err != nil {
+		t.Fatal(err)
+	}
+	// "control.tar.raw" still matches the "control.tar." member prefix NewDebianBuildpack
+	// looks for, but its contents are a plain (uncompressed) tar, so this exercises
+	// decompressMember's unrecognized-magic-bytes branch rather than failing earlier at
+	// the member lookup.
+	controlTar := newTestTar(t, map[string]string{"./control": "Package: acme/tool\nVersion: 1.0.0\n"})
+	if err := aw.WriteHeader(&ar.Header{Name: "control.tar.raw", Size: int64(len(controlTar))}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := aw.Write(controlTar); err != nil {
+		t.Fatal(err)
+	}
+	fh.Close()
+
+	if _, err := NewDebianBuildpack(debPath, "/"); err == nil {
+		t.Fatalf("expected an error for an unrecognized control.tar compression format")
+	}
+}
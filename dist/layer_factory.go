@@ -0,0 +1,96 @@
+package dist
+
+import (
+	"sync"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// LayerFactory writes buildpack layer tarballs into dest, caching them in memory by
+// (EscapedID, Version) so that a buildpack referenced by many buildpackages in the same
+// process is only ever laid down once. Combined with the reproducible-by-construction
+// output of embedBuildpackTar, repeated Get calls for the same buildpack - even across
+// separate LayerFactory instances and machines - yield byte-identical tarballs.
+type LayerFactory struct {
+	dest string
+	uid  int
+	gid  int
+	opts []LayerOption
+
+	mu       sync.Mutex
+	cache    map[layerCacheKey]layerCacheEntry
+	keyLocks map[layerCacheKey]*sync.Mutex
+}
+
+type layerCacheKey struct {
+	id      string
+	version string
+}
+
+type layerCacheEntry struct {
+	path   string
+	diffID v1.Hash
+	digest v1.Hash
+}
+
+// NewLayerFactory returns a LayerFactory that writes buildpack layers into dest,
+// normalizing ownership to uid:gid. opts are forwarded to every BuildpackLayer call, e.g.
+// WithCompressor to opt into zstd or estargz for every layer this factory produces.
+func NewLayerFactory(dest string, uid, gid int, opts ...LayerOption) *LayerFactory {
+	return &LayerFactory{
+		dest:     dest,
+		uid:      uid,
+		gid:      gid,
+		opts:     opts,
+		cache:    map[layerCacheKey]layerCacheEntry{},
+		keyLocks: map[layerCacheKey]*sync.Mutex{},
+	}
+}
+
+// Get returns the path, diffID, and digest of bp's layer tarball, generating and
+// compressing it the first time bp's (EscapedID, Version) is requested and reusing the
+// cached result for every subsequent call with the same key. Only concurrent calls for the
+// same key are serialized against each other - Gets for unrelated keys proceed in parallel,
+// rather than queuing behind whichever layer happens to be generating first.
+func (f *LayerFactory) Get(bp Buildpack) (path string, diffID, digest v1.Hash, err error) {
+	bpd := bp.Descriptor()
+	key := layerCacheKey{id: bpd.EscapedID(), version: bpd.Info.Version}
+
+	keyMu := f.lockFor(key)
+	defer keyMu.Unlock()
+
+	f.mu.Lock()
+	entry, ok := f.cache[key]
+	f.mu.Unlock()
+	if ok {
+		return entry.path, entry.diffID, entry.digest, nil
+	}
+
+	layerPath, diffID, digest, err := BuildpackLayer(f.dest, f.uid, f.gid, bp, f.opts...)
+	if err != nil {
+		return "", v1.Hash{}, v1.Hash{}, err
+	}
+
+	entry = layerCacheEntry{path: layerPath, diffID: diffID, digest: digest}
+	f.mu.Lock()
+	f.cache[key] = entry
+	f.mu.Unlock()
+	return entry.path, entry.diffID, entry.digest, nil
+}
+
+// lockFor returns key's dedicated mutex, creating it if this is the first request for key,
+// and locks it before returning. The map lookup/insert is guarded by f.mu, but that lock is
+// released before blocking on the per-key mutex, so a slow in-flight Get for one key never
+// holds up lookups or generation for any other key.
+func (f *LayerFactory) lockFor(key layerCacheKey) *sync.Mutex {
+	f.mu.Lock()
+	keyMu, ok := f.keyLocks[key]
+	if !ok {
+		keyMu = &sync.Mutex{}
+		f.keyLocks[key] = keyMu
+	}
+	f.mu.Unlock()
+
+	keyMu.Lock()
+	return keyMu
+}
@@ -0,0 +1,117 @@
+package dist
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestLayerFactory_Get(t *testing.T) {
+	dir, err := ioutil.TempDir("", "layer-factory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bp := &fakeBuildpack{
+		descriptor: BuildpackDescriptor{Info: BuildpackInfo{ID: "some/bp", Version: "1.0.0"}},
+		blob:       newTestTar(t, map[string]string{"./file.txt": "some-content"}),
+	}
+
+	f := NewLayerFactory(dir, 0, 0)
+
+	path1, diffID1, digest1, err := f.Get(bp)
+	if err != nil {
+		t.Fatalf("Get() returned error: %s", err)
+	}
+
+	if err := os.Remove(path1); err != nil {
+		t.Fatalf("removing layer tar to prove the second Get doesn't regenerate it: %s", err)
+	}
+
+	path2, diffID2, digest2, err := f.Get(bp)
+	if err != nil {
+		t.Fatalf("Get() (cached) returned error: %s", err)
+	}
+
+	if path1 != path2 || diffID1 != diffID2 || digest1 != digest2 {
+		t.Fatalf("expected cached Get to return identical results: %s/%s/%s vs %s/%s/%s", path1, diffID1, digest1, path2, diffID2, digest2)
+	}
+	if _, err := os.Stat(path2); err == nil {
+		t.Fatalf("expected cached Get not to regenerate %s on disk", path2)
+	}
+}
+
+// slowOpenBuildpack's Open signals started, then blocks until release is closed before
+// returning its blob - letting a test deterministically hold a Get call in flight.
+type slowOpenBuildpack struct {
+	fakeBuildpack
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *slowOpenBuildpack) Open() (io.ReadCloser, error) {
+	close(b.started)
+	<-b.release
+	return b.fakeBuildpack.Open()
+}
+
+// TestLayerFactory_GetDoesNotSerializeUnrelatedKeys is a regression test for the bug fixed
+// by switching LayerFactory.Get from one factory-wide mutex to per-key locking: a Get for
+// one (EscapedID, Version) key must not block a concurrent Get for a different key.
+func TestLayerFactory_GetDoesNotSerializeUnrelatedKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "layer-factory-concurrent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f := NewLayerFactory(dir, 0, 0)
+
+	slow := &slowOpenBuildpack{
+		fakeBuildpack: fakeBuildpack{
+			descriptor: BuildpackDescriptor{Info: BuildpackInfo{ID: "some/slow-bp", Version: "1.0.0"}},
+			blob:       newTestTar(t, map[string]string{"./slow.txt": "slow-content"}),
+		},
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	fast := &fakeBuildpack{
+		descriptor: BuildpackDescriptor{Info: BuildpackInfo{ID: "some/fast-bp", Version: "1.0.0"}},
+		blob:       newTestTar(t, map[string]string{"./fast.txt": "fast-content"}),
+	}
+
+	slowDone := make(chan error, 1)
+	go func() {
+		_, _, _, err := f.Get(slow)
+		slowDone <- err
+	}()
+
+	select {
+	case <-slow.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the slow Get to start reading the blocked buildpack")
+	}
+
+	fastDone := make(chan error, 1)
+	go func() {
+		_, _, _, err := f.Get(fast)
+		fastDone <- err
+	}()
+
+	select {
+	case err := <-fastDone:
+		if err != nil {
+			t.Fatalf("Get(fast) returned error: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Get(fast) was blocked behind the in-flight Get(slow) - per-key locking regressed to a single global mutex")
+	}
+
+	close(slow.release)
+	if err := <-slowDone; err != nil {
+		t.Fatalf("Get(slow) returned error: %s", err)
+	}
+}
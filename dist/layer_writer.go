@@ -0,0 +1,155 @@
+package dist
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"path"
+	"sync"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/pkg/errors"
+
+	"github.com/buildpack/pack/internal/archive"
+)
+
+// LayerOption configures a BuildpackLayerWriter.
+type LayerOption func(*layerWriterConfig)
+
+type layerWriterConfig struct {
+	compressor Compressor
+}
+
+// WithCompressor overrides the default gzip Compressor used to produce a layer's
+// compressed bytes, e.g. to opt into zstd or estargz.
+func WithCompressor(c Compressor) LayerOption {
+	return func(cfg *layerWriterConfig) {
+		cfg.compressor = c
+	}
+}
+
+// resolveLayerWriterConfig applies opts over the default (gzip) layerWriterConfig, so
+// that callers needing to know the resolved Compressor ahead of time - e.g. to name a
+// file or pick an OCI media type - see the same defaulting NewBuildpackLayerWriter uses.
+func resolveLayerWriterConfig(opts ...LayerOption) layerWriterConfig {
+	cfg := layerWriterConfig{compressor: NewGzipCompressor()}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// BuildpackLayerWriter streams a single buildpack as a compressed OCI layer: reading from
+// it yields compressed bytes as they're produced, with no uncompressed copy ever touching
+// disk. The uncompressed diffID and compressed digest are computed as those bytes stream
+// through and are only final once Close has been called.
+type BuildpackLayerWriter struct {
+	pr *io.PipeReader
+
+	tarHash  hash.Hash
+	blobHash hash.Hash
+
+	closeOnce sync.Once
+	writeDone chan error
+	closeErr  error
+}
+
+// NewBuildpackLayerWriter returns a BuildpackLayerWriter that, as it is read, streams bp's
+// contents as a tar rooted under /cnb/buildpacks/{id}/{version}, compressed with the
+// configured Compressor (gzip by default).
+func NewBuildpackLayerWriter(bp Buildpack, uid, gid int, opts ...LayerOption) (*BuildpackLayerWriter, error) {
+	cfg := resolveLayerWriterConfig(opts...)
+
+	bpd := bp.Descriptor()
+	baseTarDir := path.Join(BuildpacksDir, bpd.EscapedID(), bpd.Info.Version)
+
+	pr, pw := io.Pipe()
+
+	lw := &BuildpackLayerWriter{
+		pr:        pr,
+		tarHash:   sha256.New(),
+		blobHash:  sha256.New(),
+		writeDone: make(chan error, 1),
+	}
+
+	cw, err := cfg.compressor.Compress(io.MultiWriter(pw, lw.blobHash))
+	if err != nil {
+		pw.Close()
+		return nil, errors.Wrap(err, "creating compressor")
+	}
+
+	go func() {
+		err := lw.writeLayer(cw, uid, gid, bp, baseTarDir)
+		closeErr := cw.Close()
+		if err == nil {
+			err = closeErr
+		}
+		pw.CloseWithError(err)
+		lw.writeDone <- err
+	}()
+
+	return lw, nil
+}
+
+func (lw *BuildpackLayerWriter) writeLayer(w io.Writer, uid, gid int, bp Buildpack, baseTarDir string) error {
+	tw := tar.NewWriter(io.MultiWriter(w, lw.tarHash))
+
+	bpd := bp.Descriptor()
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     path.Join(BuildpacksDir, bpd.EscapedID()),
+		Mode:     0755,
+		ModTime:  archive.NormalizedDateTime,
+	}); err != nil {
+		return err
+	}
+
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     baseTarDir,
+		Mode:     0755,
+		ModTime:  archive.NormalizedDateTime,
+	}); err != nil {
+		return err
+	}
+
+	if err := embedBuildpackTar(tw, uid, gid, bp, baseTarDir); err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+// Read returns bp's layer as compressed bytes.
+func (lw *BuildpackLayerWriter) Read(p []byte) (int, error) {
+	return lw.pr.Read(p)
+}
+
+// Close waits for the underlying write goroutine to finish and finalizes the diffID and
+// digest. It must be called (and its error checked) before DiffID or Digest are read,
+// even if the caller has already consumed Read to io.EOF.
+func (lw *BuildpackLayerWriter) Close() error {
+	lw.closeOnce.Do(func() {
+		if err := lw.pr.Close(); err != nil {
+			lw.closeErr = err
+		}
+		if err := <-lw.writeDone; err != nil && lw.closeErr == nil {
+			lw.closeErr = err
+		}
+	})
+	return lw.closeErr
+}
+
+// DiffID returns the sha256 digest of the uncompressed tar stream. It is only valid after
+// Close has returned successfully.
+func (lw *BuildpackLayerWriter) DiffID() (v1.Hash, error) {
+	return v1.NewHash("sha256:" + hex.EncodeToString(lw.tarHash.Sum(nil)))
+}
+
+// Digest returns the sha256 digest of the compressed layer blob. It is only valid after
+// Close has returned successfully.
+func (lw *BuildpackLayerWriter) Digest() (v1.Hash, error) {
+	return v1.NewHash("sha256:" + hex.EncodeToString(lw.blobHash.Sum(nil)))
+}
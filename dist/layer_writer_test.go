@@ -0,0 +1,187 @@
+package dist
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/containerd/stargz-snapshotter/estargz"
+	"github.com/klauspost/compress/zstd"
+)
+
+func gunzipBytes(t *testing.T, b []byte) []byte {
+	t.Helper()
+
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("creating gzip reader: %s", err)
+	}
+	defer zr.Close()
+
+	plain, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("gunzipping: %s", err)
+	}
+	return plain
+}
+
+func unzstdBytes(t *testing.T, b []byte) []byte {
+	t.Helper()
+
+	zr, err := zstd.NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("creating zstd reader: %s", err)
+	}
+	defer zr.Close()
+
+	plain, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("un-zstd-ing: %s", err)
+	}
+	return plain
+}
+
+// assertLayerWriterRoundTrips drives lwOpts through NewBuildpackLayerWriter, decompresses
+// the result with decompress, and checks both the embedded tar contents and the hashes
+// BuildpackLayerWriter reports against independently-computed sha256 sums.
+func assertLayerWriterRoundTrips(t *testing.T, lwOpts []LayerOption, decompress func(*testing.T, []byte) []byte) {
+	t.Helper()
+
+	bp := &fakeBuildpack{
+		descriptor: BuildpackDescriptor{Info: BuildpackInfo{ID: "some/bp", Version: "1.0.0"}},
+		blob:       newTestTar(t, map[string]string{"./file.txt": "some-content"}),
+	}
+
+	lw, err := NewBuildpackLayerWriter(bp, 1000, 1000, lwOpts...)
+	if err != nil {
+		t.Fatalf("NewBuildpackLayerWriter() returned error: %s", err)
+	}
+
+	compressed, err := ioutil.ReadAll(lw)
+	if err != nil {
+		t.Fatalf("reading layer writer: %s", err)
+	}
+	if err := lw.Close(); err != nil {
+		t.Fatalf("Close() returned error: %s", err)
+	}
+
+	digestSum := sha256.Sum256(compressed)
+	wantDigest := "sha256:" + hex.EncodeToString(digestSum[:])
+	digest, err := lw.Digest()
+	if err != nil {
+		t.Fatalf("Digest() returned error: %s", err)
+	}
+	if digest.String() != wantDigest {
+		t.Fatalf("Digest() = %s, want %s", digest, wantDigest)
+	}
+
+	plain := decompress(t, compressed)
+
+	diffIDSum := sha256.Sum256(plain)
+	wantDiffID := "sha256:" + hex.EncodeToString(diffIDSum[:])
+	diffID, err := lw.DiffID()
+	if err != nil {
+		t.Fatalf("DiffID() returned error: %s", err)
+	}
+	if diffID.String() != wantDiffID {
+		t.Fatalf("DiffID() = %s, want %s", diffID, wantDiffID)
+	}
+
+	tr := tar.NewReader(bytes.NewReader(plain))
+	var found bool
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("reading decompressed tar: %s", err)
+		}
+		if header.Name != "/cnb/buildpacks/some_bp/1.0.0/file.txt" {
+			continue
+		}
+		found = true
+		contents, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("reading entry contents: %s", err)
+		}
+		if string(contents) != "some-content" {
+			t.Fatalf("unexpected entry contents: %s", contents)
+		}
+	}
+	if !found {
+		t.Fatalf("expected /cnb/buildpacks/some_bp/1.0.0/file.txt entry in decompressed layer")
+	}
+}
+
+func TestNewBuildpackLayerWriter(t *testing.T) {
+	t.Run("gzip (default)", func(t *testing.T) {
+		assertLayerWriterRoundTrips(t, nil, gunzipBytes)
+	})
+
+	t.Run("zstd", func(t *testing.T) {
+		assertLayerWriterRoundTrips(t, []LayerOption{WithCompressor(NewZstdCompressor())}, unzstdBytes)
+	})
+}
+
+// TestNewBuildpackLayerWriter_Estargz is split out from TestNewBuildpackLayerWriter because
+// estargz output isn't a single gzip stream over a plain tar (it's a sequence of
+// independently-gzipped chunks plus a TOC footer), so verifying it requires estargz.Open
+// rather than the gunzip-then-tar.NewReader path the other compressors share.
+func TestNewBuildpackLayerWriter_Estargz(t *testing.T) {
+	bp := &fakeBuildpack{
+		descriptor: BuildpackDescriptor{Info: BuildpackInfo{ID: "some/bp", Version: "1.0.0"}},
+		blob:       newTestTar(t, map[string]string{"./file.txt": "some-content"}),
+	}
+
+	lw, err := NewBuildpackLayerWriter(bp, 1000, 1000, WithCompressor(NewEstargzCompressor()))
+	if err != nil {
+		t.Fatalf("NewBuildpackLayerWriter() returned error: %s", err)
+	}
+
+	compressed, err := ioutil.ReadAll(lw)
+	if err != nil {
+		t.Fatalf("reading layer writer: %s", err)
+	}
+	if err := lw.Close(); err != nil {
+		t.Fatalf("Close() returned error: %s", err)
+	}
+
+	digestSum := sha256.Sum256(compressed)
+	wantDigest := "sha256:" + hex.EncodeToString(digestSum[:])
+	digest, err := lw.Digest()
+	if err != nil {
+		t.Fatalf("Digest() returned error: %s", err)
+	}
+	if digest.String() != wantDigest {
+		t.Fatalf("Digest() = %s, want %s", digest, wantDigest)
+	}
+
+	sr := io.NewSectionReader(bytes.NewReader(compressed), 0, int64(len(compressed)))
+	r, err := estargz.Open(sr)
+	if err != nil {
+		t.Fatalf("estargz.Open() returned error: %s", err)
+	}
+
+	entry, ok := r.Lookup("cnb/buildpacks/some_bp/1.0.0/file.txt")
+	if !ok {
+		t.Fatalf("expected cnb/buildpacks/some_bp/1.0.0/file.txt entry in estargz layer")
+	}
+
+	fr, err := r.OpenFile("cnb/buildpacks/some_bp/1.0.0/file.txt")
+	if err != nil {
+		t.Fatalf("OpenFile() returned error: %s", err)
+	}
+	contents := make([]byte, entry.Size)
+	if _, err := fr.ReadAt(contents, 0); err != nil && err != io.EOF {
+		t.Fatalf("reading entry contents: %s", err)
+	}
+	if string(contents) != "some-content" {
+		t.Fatalf("unexpected entry contents: %s", contents)
+	}
+}
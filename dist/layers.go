@@ -2,15 +2,17 @@ package dist
 
 import (
 	"archive/tar"
+	"bufio"
+	"bytes"
 	"compress/gzip"
-	"crypto/sha256"
-	"encoding/hex"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
+	"time"
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/pkg/errors"
@@ -20,71 +22,127 @@ import (
 
 const BuildpacksDir = "/cnb/buildpacks"
 
+// gzipMagic is the first two bytes of a gzip stream, RFC 1952 section 2.3.1.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// layerFileExtensions maps a Compressor's MediaType() to the file extension its output
+// is conventionally given on disk.
+var layerFileExtensions = map[string]string{
+	"gzip":    "gz",
+	"zstd":    "zst",
+	"estargz": "gz",
+}
+
 // Output:
 //
-// layer tar = {ID}.{V}.tar
+// layer tar = {ID}.{V}.tar.gz (or another extension, per the configured Compressor)
 //
 // inside the layer = /cnbs/buildpacks/{ID}/{V}/*
-func BuildpackLayer(dest string, uid, gid int, bp Buildpack) (string, error) {
+//
+// BuildpackLayer writes bp's compressed layer tarball to dest and returns its path
+// alongside the diffID of its uncompressed contents and the digest of the compressed
+// blob - both computed as the bytes stream through BuildpackLayerWriter, rather than by
+// a second read-and-gzip pass over the file once it's on disk. opts are forwarded to
+// NewBuildpackLayerWriter, e.g. to select a Compressor other than the gzip default.
+func BuildpackLayer(dest string, uid, gid int, bp Buildpack, opts ...LayerOption) (path string, diffID, digest v1.Hash, err error) {
+	lw, err := NewBuildpackLayerWriter(bp, uid, gid, opts...)
+	if err != nil {
+		return "", v1.Hash{}, v1.Hash{}, err
+	}
+
 	bpd := bp.Descriptor()
-	layerTar := filepath.Join(dest, fmt.Sprintf("%s.%s.tar", bpd.EscapedID(), bpd.Info.Version))
+	mediaType := resolveLayerWriterConfig(opts...).compressor.MediaType()
+	ext, ok := layerFileExtensions[mediaType]
+	if !ok {
+		ext = mediaType
+	}
+	layerTar := filepath.Join(dest, fmt.Sprintf("%s.%s.tar.%s", bpd.EscapedID(), bpd.Info.Version, ext))
 
 	fh, err := os.Create(layerTar)
 	if err != nil {
-		return "", fmt.Errorf("create file for tar: %s", err)
+		return "", v1.Hash{}, v1.Hash{}, fmt.Errorf("create file for tar: %s", err)
 	}
 	defer fh.Close()
 
-	tw := tar.NewWriter(fh)
-	defer tw.Close()
-
-	ts := archive.NormalizedDateTime
-
-	if err := tw.WriteHeader(&tar.Header{
-		Typeflag: tar.TypeDir,
-		Name:     path.Join(BuildpacksDir, bpd.EscapedID()),
-		Mode:     0755,
-		ModTime:  ts,
-	}); err != nil {
-		return "", err
+	if _, err := io.Copy(fh, lw); err != nil {
+		lw.Close()
+		return "", v1.Hash{}, v1.Hash{}, errors.Wrapf(err, "creating layer tar for buildpack '%s:%s'", bpd.Info.ID, bpd.Info.Version)
 	}
-
-	baseTarDir := path.Join(BuildpacksDir, bpd.EscapedID(), bpd.Info.Version)
-	if err := tw.WriteHeader(&tar.Header{
-		Typeflag: tar.TypeDir,
-		Name:     baseTarDir,
-		Mode:     0755,
-		ModTime:  ts,
-	}); err != nil {
-		return "", err
+	if err := lw.Close(); err != nil {
+		return "", v1.Hash{}, v1.Hash{}, errors.Wrapf(err, "creating layer tar for buildpack '%s:%s'", bpd.Info.ID, bpd.Info.Version)
 	}
 
-	if err := embedBuildpackTar(tw, uid, gid, bp, baseTarDir); err != nil {
-		return "", errors.Wrapf(err, "creating layer tar for buildpack '%s:%s'", bpd.Info.ID, bpd.Info.Version)
+	if diffID, err = lw.DiffID(); err != nil {
+		return "", v1.Hash{}, v1.Hash{}, err
+	}
+	if digest, err = lw.Digest(); err != nil {
+		return "", v1.Hash{}, v1.Hash{}, err
 	}
 
-	return layerTar, nil
+	return layerTar, diffID, digest, nil
 }
 
+// embedBuildpackTar copies bp's contents into tw, relocated under baseTarDir.
+//
+// This is the authoritative statement of the Buildpack.Open() contract: Open may return
+// either a plain tar or a gzip-compressed tar (a very common on-disk format for buildpack
+// blobs, e.g. a `.tgz` file) - the first two bytes are sniffed for the gzip magic number
+// and a gzip.Reader is transparently interposed when present. Implementations of Buildpack
+// (see debianBuildpack for one) should document their own Open() by reference to this rule
+// rather than repeating it.
+//
+// Every header is normalized (see normalizeHeader) and entries are written in sorted
+// order, so that two invocations of embedBuildpackTar over the same buildpack, on any
+// machine, produce byte-identical output.
 func embedBuildpackTar(tw *tar.Writer, uid, gid int, bp Buildpack, baseTarDir string) error {
-	var (
-		err error
-	)
-
 	rc, err := bp.Open()
 	if err != nil {
 		return errors.Wrap(err, "read buildpack blob")
 	}
 	defer rc.Close()
 
-	tr := tar.NewReader(rc)
+	r, err := maybeDecompress(rc)
+	if err != nil {
+		return errors.Wrap(err, "read buildpack blob")
+	}
+
+	entries, err := readNormalizedEntries(r, uid, gid, baseTarDir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := tw.WriteHeader(entry.header); err != nil {
+			return errors.Wrapf(err, "failed to write header for '%s'", entry.header.Name)
+		}
+
+		if _, err := tw.Write(entry.contents); err != nil {
+			return errors.Wrapf(err, "failed to write contents to '%s'", entry.header.Name)
+		}
+	}
+
+	return nil
+}
+
+type tarEntry struct {
+	header   *tar.Header
+	contents []byte
+}
+
+// readNormalizedEntries reads every entry out of the tar stream r, normalizes its header,
+// and returns all entries sorted by name, so the result is independent of the order they
+// appeared in the source tar.
+func readNormalizedEntries(r io.Reader, uid, gid int, baseTarDir string) ([]tarEntry, error) {
+	tr := tar.NewReader(r)
+
+	var entries []tarEntry
 	for {
 		header, err := tr.Next()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return errors.Wrap(err, "failed to get next tar entry")
+			return nil, errors.Wrap(err, "failed to get next tar entry")
 		}
 
 		header.Name = path.Clean(header.Name)
@@ -92,65 +150,61 @@ func embedBuildpackTar(tw *tar.Writer, uid, gid int, bp Buildpack, baseTarDir st
 			continue
 		}
 
-		header.Name = path.Clean(path.Join(baseTarDir, header.Name))
-		header.Uid = uid
-		header.Gid = gid
-		err = tw.WriteHeader(header)
-		if err != nil {
-			return errors.Wrapf(err, "failed to write header for '%s'", header.Name)
-		}
-
-		buf, err := ioutil.ReadAll(tr)
-		if err != nil {
-			return errors.Wrapf(err, "failed to read contents of '%s'", header.Name)
-		}
+		normalizeHeader(header, uid, gid, baseTarDir)
 
-		_, err = tw.Write(buf)
+		contents, err := ioutil.ReadAll(tr)
 		if err != nil {
-			return errors.Wrapf(err, "failed to write contents to '%s'", header.Name)
+			return nil, errors.Wrapf(err, "failed to read contents of '%s'", header.Name)
 		}
-	}
 
-	return nil
-}
-
-func LayerHashes(layerTarPath string) (diffID v1.Hash, digest v1.Hash, err error) {
-	fh, err := os.Open(layerTarPath)
-	if err != nil {
-		return v1.Hash{}, v1.Hash{}, errors.Wrap(err, "opening tar file")
+		entries = append(entries, tarEntry{header: header, contents: contents})
 	}
-	defer fh.Close()
-
-	// h <----------------- +
-	//                      |
-	// zh <- zw (gunzip) <- + <-- fh
 
-	h := sha256.New()
-	zh := sha256.New()
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].header.Name < entries[j].header.Name
+	})
 
-	zw, err := gzip.NewWriterLevel(zh, gzip.DefaultCompression)
-	if err != nil {
-		return v1.Hash{}, v1.Hash{}, errors.Wrap(err, "compressing tar")
-	}
-	defer zw.Close()
+	return entries, nil
+}
 
-	if _, err := io.Copy(io.MultiWriter(h, zw), fh); err != nil {
-		return v1.Hash{}, v1.Hash{}, errors.Wrap(err, "compressing tar")
+// normalizeHeader strips everything from header that could make an otherwise identical
+// buildpack produce a different tarball on a different run or machine: ownership is
+// pinned to uid/gid, the mode is masked down to permission bits, ModTime collapses to
+// archive.NormalizedDateTime, and AccessTime/ChangeTime/PAX records/xattrs/owner names -
+// which the USTAR format can't even represent without falling back to PAX - are dropped
+// rather than normalized, since dropping them is itself the reproducible value.
+func normalizeHeader(header *tar.Header, uid, gid int, baseTarDir string) {
+	header.Name = path.Clean(path.Join(baseTarDir, header.Name))
+	if header.Typeflag == tar.TypeLink {
+		// Linkname for a hardlink refers to another entry's original (pre-reroot) Name,
+		// so it needs the same treatment or the link ends up dangling.
+		header.Linkname = path.Clean(path.Join(baseTarDir, header.Linkname))
 	}
+	header.Uid = uid
+	header.Gid = gid
+	header.Uname = ""
+	header.Gname = ""
+	header.Mode = header.Mode & 0777
+	header.ModTime = archive.NormalizedDateTime
+	header.AccessTime = time.Time{}
+	header.ChangeTime = time.Time{}
+	header.PAXRecords = nil
+	header.Xattrs = nil //nolint:staticcheck
+}
 
-	if err := zw.Close(); err != nil {
-		return v1.Hash{}, v1.Hash{}, errors.Wrap(err, "closing tar")
-	}
+// maybeDecompress sniffs r for the gzip magic number, wrapping it with a gzip.Reader when
+// present and otherwise returning it untouched as a plain tar stream.
+func maybeDecompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
 
-	diffID, err = v1.NewHash("sha256:" + hex.EncodeToString(h.Sum(nil)))
-	if err != nil {
-		return v1.Hash{}, v1.Hash{}, errors.Wrap(err, "generating diff_id")
+	magic, err := br.Peek(len(gzipMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
 	}
 
-	digest, err = v1.NewHash("sha256:" + hex.EncodeToString(zh.Sum(nil)))
-	if err != nil {
-		return v1.Hash{}, v1.Hash{}, errors.Wrap(err, "generating digest")
+	if bytes.Equal(magic, gzipMagic) {
+		return gzip.NewReader(br)
 	}
 
-	return diffID, digest, nil
+	return br, nil
 }
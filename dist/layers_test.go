@@ -0,0 +1,212 @@
+package dist
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+type fakeBuildpack struct {
+	descriptor BuildpackDescriptor
+	blob       []byte
+}
+
+func (f *fakeBuildpack) Descriptor() BuildpackDescriptor {
+	return f.descriptor
+}
+
+func (f *fakeBuildpack) Open() (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader(f.blob)), nil
+}
+
+func newTestTar(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for name, contents := range files {
+		if err := tw.WriteHeader(&tar.Header{
+			Name: name,
+			Mode: 0644,
+			Size: int64(len(contents)),
+		}); err != nil {
+			t.Fatalf("writing header: %s", err)
+		}
+		if _, err := tw.Write([]byte(contents)); err != nil {
+			t.Fatalf("writing contents: %s", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("closing tar: %s", err)
+	}
+
+	return buf.Bytes()
+}
+
+func gzipBytes(t *testing.T, b []byte) []byte {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	zw := gzip.NewWriter(buf)
+	if _, err := zw.Write(b); err != nil {
+		t.Fatalf("gzipping: %s", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("closing gzip writer: %s", err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestEmbedBuildpackTar(t *testing.T) {
+	for _, gzipped := range []bool{false, true} {
+		gzipped := gzipped
+		name := "plain tar"
+		if gzipped {
+			name = "gzipped tar"
+		}
+
+		t.Run(name, func(t *testing.T) {
+			blob := newTestTar(t, map[string]string{"./file.txt": "some-content"})
+			if gzipped {
+				blob = gzipBytes(t, blob)
+			}
+
+			bp := &fakeBuildpack{blob: blob}
+
+			buf := &bytes.Buffer{}
+			tw := tar.NewWriter(buf)
+			if err := embedBuildpackTar(tw, 0, 0, bp, "/cnb/buildpacks/some-id/some-version"); err != nil {
+				t.Fatalf("embedBuildpackTar() returned error: %s", err)
+			}
+			if err := tw.Close(); err != nil {
+				t.Fatalf("closing tar writer: %s", err)
+			}
+
+			tr := tar.NewReader(buf)
+			header, err := tr.Next()
+			if err != nil {
+				t.Fatalf("reading embedded tar: %s", err)
+			}
+			if header.Name != "/cnb/buildpacks/some-id/some-version/file.txt" {
+				t.Fatalf("unexpected entry name: %s", header.Name)
+			}
+
+			contents, err := ioutil.ReadAll(tr)
+			if err != nil {
+				t.Fatalf("reading entry contents: %s", err)
+			}
+			if string(contents) != "some-content" {
+				t.Fatalf("unexpected entry contents: %s", contents)
+			}
+		})
+	}
+}
+
+func TestEmbedBuildpackTar_RewritesHardlinkLinkname(t *testing.T) {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	if err := tw.WriteHeader(&tar.Header{Name: "./original.txt", Mode: 0644, Size: int64(len("some-content"))}); err != nil {
+		t.Fatalf("writing original header: %s", err)
+	}
+	if _, err := tw.Write([]byte("some-content")); err != nil {
+		t.Fatalf("writing original contents: %s", err)
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: "./hardlink.txt", Typeflag: tar.TypeLink, Linkname: "./original.txt"}); err != nil {
+		t.Fatalf("writing hardlink header: %s", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("closing source tar: %s", err)
+	}
+
+	bp := &fakeBuildpack{blob: buf.Bytes()}
+
+	out := &bytes.Buffer{}
+	outTw := tar.NewWriter(out)
+	if err := embedBuildpackTar(outTw, 0, 0, bp, "/cnb/buildpacks/some-id/some-version"); err != nil {
+		t.Fatalf("embedBuildpackTar() returned error: %s", err)
+	}
+	if err := outTw.Close(); err != nil {
+		t.Fatalf("closing tar writer: %s", err)
+	}
+
+	names := map[string]bool{}
+	tr := tar.NewReader(out)
+	var hardlink *tar.Header
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("reading embedded tar: %s", err)
+		}
+		names[header.Name] = true
+		if header.Typeflag == tar.TypeLink {
+			h := *header
+			hardlink = &h
+		}
+	}
+
+	if hardlink == nil {
+		t.Fatal("expected a hardlink entry in the embedded tar")
+	}
+	if !names[hardlink.Linkname] {
+		t.Fatalf("hardlink Linkname %q does not resolve to any entry in the embedded tar (entries: %v)", hardlink.Linkname, names)
+	}
+	if hardlink.Linkname != "/cnb/buildpacks/some-id/some-version/original.txt" {
+		t.Fatalf("unexpected hardlink Linkname: %s", hardlink.Linkname)
+	}
+}
+
+func TestBuildpackLayerIsReproducible(t *testing.T) {
+	dir, err := ioutil.TempDir("", "buildpack-layer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bp := &fakeBuildpack{
+		descriptor: BuildpackDescriptor{Info: BuildpackInfo{ID: "some/bp", Version: "1.2.3"}},
+		blob:       newTestTar(t, map[string]string{"./b.txt": "bbb", "./a.txt": "aaa"}),
+	}
+
+	path1, diffID1, digest1, err := BuildpackLayer(dir, 0, 0, bp)
+	if err != nil {
+		t.Fatalf("BuildpackLayer() returned error: %s", err)
+	}
+	contents1, err := ioutil.ReadFile(path1)
+	if err != nil {
+		t.Fatalf("reading layer tar: %s", err)
+	}
+
+	if err := os.Remove(path1); err != nil {
+		t.Fatalf("removing layer tar: %s", err)
+	}
+
+	path2, diffID2, digest2, err := BuildpackLayer(dir, 0, 0, bp)
+	if err != nil {
+		t.Fatalf("BuildpackLayer() returned error on second run: %s", err)
+	}
+	contents2, err := ioutil.ReadFile(path2)
+	if err != nil {
+		t.Fatalf("reading layer tar (second run): %s", err)
+	}
+
+	if path1 != path2 {
+		t.Fatalf("expected identical path, got %q and %q", path1, path2)
+	}
+	if diffID1 != diffID2 {
+		t.Fatalf("expected identical diffID, got %s and %s", diffID1, diffID2)
+	}
+	if digest1 != digest2 {
+		t.Fatalf("expected identical digest, got %s and %s", digest1, digest2)
+	}
+	if !bytes.Equal(contents1, contents2) {
+		t.Fatalf("expected byte-identical layer tarballs across runs")
+	}
+}
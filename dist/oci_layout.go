@@ -0,0 +1,172 @@
+package dist
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	ociLayoutVersion   = "1.0.0"
+	ociImageConfigType = "application/vnd.oci.image.config.v1+json"
+	ociManifestType    = "application/vnd.oci.image.manifest.v1+json"
+)
+
+// ociLayerMediaTypes maps a Compressor's MediaType() to the OCI layer media type that
+// describes it. estargz layers are still valid gzip streams, so they're advertised the
+// same way gzip is - only zstd needs a distinct media type.
+var ociLayerMediaTypes = map[string]string{
+	"gzip":    "application/vnd.oci.image.layer.v1.tar+gzip",
+	"zstd":    "application/vnd.oci.image.layer.v1.tar+zstd",
+	"estargz": "application/vnd.oci.image.layer.v1.tar+gzip",
+}
+
+type ociLayoutMarker struct {
+	ImageLayoutVersion string `json:"imageLayoutVersion"`
+}
+
+type ociDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+type ociManifest struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	Config        ociDescriptor   `json:"config"`
+	Layers        []ociDescriptor `json:"layers"`
+}
+
+type ociIndex struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	Manifests     []ociDescriptor `json:"manifests"`
+}
+
+type ociBuildpackageConfig struct {
+	Buildpacks []BuildpackInfo `json:"buildpacks"`
+}
+
+// WriteOCILayout writes bps to dir as a spec-compliant OCI image layout: an oci-layout
+// marker file, a blobs/sha256 directory holding each buildpack's layer blob alongside a
+// config blob and a manifest, and an index.json referencing that manifest. The result can
+// be consumed directly by skopeo, crane, and other OCI tooling without a Docker daemon or
+// registry. opts are forwarded to NewBuildpackLayerWriter for every layer, e.g.
+// WithCompressor to opt into zstd or estargz instead of the gzip default.
+func WriteOCILayout(dir string, bps []Buildpack, uid, gid int, opts ...LayerOption) error {
+	blobsDir := filepath.Join(dir, "blobs", "sha256")
+	if err := os.MkdirAll(blobsDir, 0755); err != nil {
+		return errors.Wrap(err, "creating blobs directory")
+	}
+
+	layers := make([]ociDescriptor, 0, len(bps))
+	infos := make([]BuildpackInfo, 0, len(bps))
+	for _, bp := range bps {
+		desc, err := writeOCILayerBlob(blobsDir, bp, uid, gid, opts...)
+		if err != nil {
+			return errors.Wrapf(err, "writing layer for buildpack '%s'", bp.Descriptor().Info.ID)
+		}
+		layers = append(layers, desc)
+		infos = append(infos, bp.Descriptor().Info)
+	}
+
+	config, err := writeOCIJSONBlob(blobsDir, ociImageConfigType, ociBuildpackageConfig{Buildpacks: infos})
+	if err != nil {
+		return errors.Wrap(err, "writing config blob")
+	}
+
+	manifest, err := writeOCIJSONBlob(blobsDir, ociManifestType, ociManifest{
+		SchemaVersion: 2,
+		Config:        config,
+		Layers:        layers,
+	})
+	if err != nil {
+		return errors.Wrap(err, "writing manifest blob")
+	}
+
+	if err := writeOCIJSONFile(filepath.Join(dir, "oci-layout"), ociLayoutMarker{ImageLayoutVersion: ociLayoutVersion}); err != nil {
+		return errors.Wrap(err, "writing oci-layout")
+	}
+
+	if err := writeOCIJSONFile(filepath.Join(dir, "index.json"), ociIndex{
+		SchemaVersion: 2,
+		Manifests:     []ociDescriptor{manifest},
+	}); err != nil {
+		return errors.Wrap(err, "writing index.json")
+	}
+
+	return nil
+}
+
+// writeOCILayerBlob streams bp into a temporary file in blobsDir, reusing
+// BuildpackLayerWriter so the compressed bytes are written once and no uncompressed copy
+// touches disk, then renames that file to its content-addressed name once the digest is
+// known.
+func writeOCILayerBlob(blobsDir string, bp Buildpack, uid, gid int, opts ...LayerOption) (ociDescriptor, error) {
+	compressorName := resolveLayerWriterConfig(opts...).compressor.MediaType()
+	mediaType, ok := ociLayerMediaTypes[compressorName]
+	if !ok {
+		return ociDescriptor{}, errors.Errorf("no OCI media type known for compressor %q", compressorName)
+	}
+
+	lw, err := NewBuildpackLayerWriter(bp, uid, gid, opts...)
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+
+	tmp, err := ioutil.TempFile(blobsDir, "blob-")
+	if err != nil {
+		return ociDescriptor{}, errors.Wrap(err, "creating temp blob file")
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	size, err := io.Copy(tmp, lw)
+	if err != nil {
+		lw.Close()
+		return ociDescriptor{}, errors.Wrap(err, "writing layer blob")
+	}
+	if err := lw.Close(); err != nil {
+		return ociDescriptor{}, errors.Wrap(err, "closing layer writer")
+	}
+
+	digest, err := lw.Digest()
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+
+	if err := os.Rename(tmp.Name(), filepath.Join(blobsDir, digest.Hex)); err != nil {
+		return ociDescriptor{}, errors.Wrap(err, "moving blob into place")
+	}
+
+	return ociDescriptor{MediaType: mediaType, Digest: digest.String(), Size: size}, nil
+}
+
+func writeOCIJSONBlob(blobsDir, mediaType string, v interface{}) (ociDescriptor, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+
+	sum := sha256.Sum256(b)
+	digestHex := hex.EncodeToString(sum[:])
+
+	if err := ioutil.WriteFile(filepath.Join(blobsDir, digestHex), b, 0644); err != nil {
+		return ociDescriptor{}, err
+	}
+
+	return ociDescriptor{MediaType: mediaType, Digest: "sha256:" + digestHex, Size: int64(len(b))}, nil
+}
+
+func writeOCIJSONFile(path string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
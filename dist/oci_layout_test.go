@@ -0,0 +1,126 @@
+package dist
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteOCILayout(t *testing.T) {
+	bp := &fakeBuildpack{
+		descriptor: BuildpackDescriptor{Info: BuildpackInfo{ID: "some/bp", Version: "1.0.0", Name: "Some Buildpack"}},
+		blob:       newTestTar(t, map[string]string{"./file.txt": "some-content"}),
+	}
+
+	dir, err := ioutil.TempDir("", "oci-layout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := WriteOCILayout(dir, []Buildpack{bp}, 1000, 1000); err != nil {
+		t.Fatalf("WriteOCILayout() returned error: %s", err)
+	}
+
+	markerBytes, err := ioutil.ReadFile(filepath.Join(dir, "oci-layout"))
+	if err != nil {
+		t.Fatalf("reading oci-layout: %s", err)
+	}
+	var marker ociLayoutMarker
+	if err := json.Unmarshal(markerBytes, &marker); err != nil {
+		t.Fatalf("unmarshal oci-layout: %s", err)
+	}
+	if marker.ImageLayoutVersion != ociLayoutVersion {
+		t.Fatalf("ImageLayoutVersion = %q, want %q", marker.ImageLayoutVersion, ociLayoutVersion)
+	}
+
+	indexBytes, err := ioutil.ReadFile(filepath.Join(dir, "index.json"))
+	if err != nil {
+		t.Fatalf("reading index.json: %s", err)
+	}
+	var index ociIndex
+	if err := json.Unmarshal(indexBytes, &index); err != nil {
+		t.Fatalf("unmarshal index.json: %s", err)
+	}
+	if len(index.Manifests) != 1 {
+		t.Fatalf("expected 1 manifest, got %d", len(index.Manifests))
+	}
+	if index.Manifests[0].MediaType != ociManifestType {
+		t.Fatalf("manifest descriptor MediaType = %q, want %q", index.Manifests[0].MediaType, ociManifestType)
+	}
+
+	manifestBytes := readBlob(t, dir, index.Manifests[0].Digest)
+	var manifest ociManifest
+	if err := json.Unmarshal(manifestBytes, &manifest); err != nil {
+		t.Fatalf("unmarshal manifest: %s", err)
+	}
+	if manifest.SchemaVersion != 2 {
+		t.Fatalf("manifest SchemaVersion = %d, want 2", manifest.SchemaVersion)
+	}
+	if len(manifest.Layers) != 1 {
+		t.Fatalf("expected 1 layer, got %d", len(manifest.Layers))
+	}
+	if manifest.Layers[0].MediaType != "application/vnd.oci.image.layer.v1.tar+gzip" {
+		t.Fatalf("layer MediaType = %q, want gzip layer media type", manifest.Layers[0].MediaType)
+	}
+
+	configBytes := readBlob(t, dir, manifest.Config.Digest)
+	var config ociBuildpackageConfig
+	if err := json.Unmarshal(configBytes, &config); err != nil {
+		t.Fatalf("unmarshal config: %s", err)
+	}
+	if len(config.Buildpacks) != 1 || config.Buildpacks[0].ID != "some/bp" || config.Buildpacks[0].Version != "1.0.0" {
+		t.Fatalf("unexpected config buildpacks: %+v", config.Buildpacks)
+	}
+
+	layerBytes := readBlob(t, dir, manifest.Layers[0].Digest)
+	zr, err := gzip.NewReader(bytes.NewReader(layerBytes))
+	if err != nil {
+		t.Fatalf("gunzipping layer blob: %s", err)
+	}
+	defer zr.Close()
+
+	tr := tar.NewReader(zr)
+	var found bool
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			break
+		}
+		if header.Name == "/cnb/buildpacks/some_bp/1.0.0/file.txt" {
+			found = true
+			contents, err := ioutil.ReadAll(tr)
+			if err != nil {
+				t.Fatalf("reading entry contents: %s", err)
+			}
+			if string(contents) != "some-content" {
+				t.Fatalf("unexpected entry contents: %s", contents)
+			}
+		}
+	}
+	if !found {
+		t.Fatalf("expected /cnb/buildpacks/some_bp/1.0.0/file.txt entry in layer blob")
+	}
+}
+
+// readBlob reads the blobs/sha256/<hex> file named by an OCI digest string (e.g.
+// "sha256:abcd...").
+func readBlob(t *testing.T, ociLayoutDir, digest string) []byte {
+	t.Helper()
+
+	const prefix = "sha256:"
+	if len(digest) <= len(prefix) || digest[:len(prefix)] != prefix {
+		t.Fatalf("unexpected digest format: %q", digest)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(ociLayoutDir, "blobs", "sha256", digest[len(prefix):]))
+	if err != nil {
+		t.Fatalf("reading blob %s: %s", digest, err)
+	}
+	return b
+}